@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/api/compute/v1"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// Driver abstracts the GCE, GCS, and Cloud KMS API calls used by the
+// googlecompute-import post-processor so that it can be exercised in
+// tests without talking to Google Cloud.
+type Driver interface {
+	// UploadToBucket uploads data to gcsObject in bucket as a resumable,
+	// retryable upload, optionally encrypting it with a customer-managed
+	// key, and returns the gs:// path of the resulting object.
+	UploadToBucket(bucket, gcsObject string, data io.Reader, key *CustomerEncryptionKey) (string, error)
+
+	// DeleteFromBucket removes an object from a GCS bucket.
+	DeleteFromBucket(bucket, gcsObject string) error
+
+	// GetObjectHash returns the hash GCS reports for an existing object
+	// using the given algorithm ("md5" or "crc32c"), and whether the
+	// object exists at all. hash is empty and exists is false if the
+	// object does not exist.
+	GetObjectHash(bucket, gcsObject, algorithm string) (hash string, exists bool, err error)
+
+	// CreateImageFromRaw creates a GCE image from a RAW disk tarball
+	// already uploaded to GCS.
+	CreateImageFromRaw(project, tarballGcsPath, name, description, family string, labels map[string]string, guestOsFeatures []string, shieldedVMStateConfig *compute.InitialStateConfig, storageLocations []string, architecture string, key *CustomerEncryptionKey) (<-chan *compute.Image, <-chan error)
+
+	// ImportVirtualDisk imports a non-RAW virtual disk (VMDK, VHD, VHDX,
+	// QCOW2) already uploaded to GCS using GCE's virtual disk import
+	// workflow (Cloud Build + Daisy), and returns the resulting image.
+	ImportVirtualDisk(ctx context.Context, project, diskGcsPath, sourceFormat, name, description, family string, labels map[string]string, guestOsFeatures []string, shieldedVMStateConfig *compute.InitialStateConfig, storageLocations []string, architecture string, key *CustomerEncryptionKey) (<-chan *compute.Image, <-chan error)
+
+	// CreateMachineImage creates a GCE Machine Image bundling sourceImageSelfLink
+	// with instance configuration, and returns the resulting machine image.
+	CreateMachineImage(project, name, description, sourceImageSelfLink string, storageLocations []string, sourceInstanceProperties *SourceInstanceProperties) (*compute.MachineImage, error)
+
+	// ExportImageToGCS boots a temporary instance from imageSelfLink, dd's
+	// its boot disk to destination, and tears the instance back down.
+	ExportImageToGCS(ctx context.Context, project, imageSelfLink, destination string, exportCfg ExportConfig) error
+}
+
+// GCEDriverConfig holds the options needed to build a Driver.
+type GCEDriverConfig struct {
+	Ui     packersdk.Ui
+	Scopes []string
+
+	// UploadChunkSizeMB is the chunk size, in MiB, used for resumable GCS
+	// uploads performed by UploadToBucket. See Config.UploadChunkSizeMB.
+	UploadChunkSizeMB int
+}
+
+// CustomerEncryptionKey is a customer-managed encryption key used to
+// encrypt/decrypt a GCS object or GCE image, mirroring
+// compute.CustomerEncryptionKey and the googlecompute builder's
+// customer_encryption_key. Exactly one of KmsKeyName, RawKey, or
+// RsaEncryptedKey must be set.
+type CustomerEncryptionKey struct {
+	// The name of a Cloud KMS key, e.g.
+	// `projects/p/locations/l/keyRings/r/cryptoKeys/k`.
+	KmsKeyName string `mapstructure:"kms_key_name"`
+	// A 256-bit customer-supplied encryption key, encoded in base64.
+	RawKey string `mapstructure:"raw_key"`
+	// An RSA-wrapped 2048-bit customer-supplied encryption key, encoded in
+	// base64.
+	RsaEncryptedKey string `mapstructure:"rsa_encrypted_key"`
+}
+
+// GuestAccelerator describes a GPU or TPU to attach to a machine image's
+// source instance properties.
+type GuestAccelerator struct {
+	Type  string `mapstructure:"type"`
+	Count int64  `mapstructure:"count"`
+}
+
+// SourceInstanceProperties carries instance-shape hints to associate with
+// a Machine Image, such as guest accelerators and a machine type hint.
+type SourceInstanceProperties struct {
+	MachineType       string             `mapstructure:"machine_type"`
+	GuestAccelerators []GuestAccelerator `mapstructure:"guest_accelerators"`
+}
+
+// ExportConfig configures the temporary instance ExportImageToGCS uses to
+// export an image's disk to GCS.
+type ExportConfig struct {
+	DiskSizeGb          int64
+	DiskType            string
+	MachineType         string
+	Zone                string
+	Network             string
+	Subnetwork          string
+	ServiceAccountEmail string
+}
@@ -0,0 +1,242 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// DriverGCE is the Driver implementation backed by the real GCE, GCS, and
+// Cloud KMS APIs.
+type DriverGCE struct {
+	ui     packersdk.Ui
+	client *http.Client
+
+	computeService  *compute.Service
+	storageService  *storage.Service
+	cloudbuildSvc   *cloudbuild.Service
+	cloudkmsService *cloudkms.Service
+	uploader        *resumableUploader
+}
+
+// NewDriverGCE builds a Driver from the given configuration, authenticating
+// with the application default credentials scoped to config.Scopes.
+func NewDriverGCE(config GCEDriverConfig) (Driver, error) {
+	ctx := context.Background()
+
+	client, err := google.DefaultClient(ctx, config.Scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create an authenticated HTTP client: %s", err)
+	}
+
+	computeService, err := compute.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client: %s", err)
+	}
+
+	storageService, err := storage.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %s", err)
+	}
+
+	cloudbuildService, err := cloudbuild.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud build client: %s", err)
+	}
+
+	cloudkmsService, err := cloudkms.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud kms client: %s", err)
+	}
+
+	return &DriverGCE{
+		ui:              config.Ui,
+		client:          client,
+		computeService:  computeService,
+		storageService:  storageService,
+		cloudbuildSvc:   cloudbuildService,
+		cloudkmsService: cloudkmsService,
+		uploader:        newResumableUploader(client, config.Ui, config.UploadChunkSizeMB),
+	}, nil
+}
+
+func (d *DriverGCE) UploadToBucket(bucket, gcsObject string, data io.Reader, key *CustomerEncryptionKey) (string, error) {
+	f, ok := data.(readSeekSizer)
+	if !ok {
+		return "", fmt.Errorf("UploadToBucket requires a seekable file-like reader")
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine upload size: %s", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind upload source: %s", err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash upload source: %s", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind upload source: %s", err)
+	}
+	contentHash := hex.EncodeToString(hash.Sum(nil))
+
+	return d.uploader.upload(bucket, gcsObject, f, size, contentHash, key)
+}
+
+// readSeekSizer is the subset of *os.File behavior UploadToBucket needs
+// in order to size, hash, and resume an upload.
+type readSeekSizer interface {
+	io.ReadSeeker
+}
+
+func (d *DriverGCE) DeleteFromBucket(bucket, gcsObject string) error {
+	return d.storageService.Objects.Delete(bucket, gcsObject).Do()
+}
+
+func (d *DriverGCE) GetObjectHash(bucket, gcsObject, algorithm string) (string, bool, error) {
+	obj, err := d.storageService.Objects.Get(bucket, gcsObject).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to get object metadata for gs://%s/%s: %s", bucket, gcsObject, err)
+	}
+
+	switch algorithm {
+	case "crc32c":
+		return obj.Crc32c, true, nil
+	default:
+		return obj.Md5Hash, true, nil
+	}
+}
+
+func (d *DriverGCE) CreateImageFromRaw(project, tarballGcsPath, name, description, family string, labels map[string]string, guestOsFeatures []string, shieldedVMStateConfig *compute.InitialStateConfig, storageLocations []string, architecture string, key *CustomerEncryptionKey) (<-chan *compute.Image, <-chan error) {
+	imageCh := make(chan *compute.Image, 1)
+	errCh := make(chan error, 1)
+
+	image := &compute.Image{
+		Name:                         name,
+		Description:                  description,
+		Family:                       family,
+		Labels:                       labels,
+		GuestOsFeatures:              guestOsFeaturesFromStrings(guestOsFeatures),
+		RawDisk:                      &compute.ImageRawDisk{Source: tarballGcsPath, ContainerType: "TAR"},
+		ShieldedInstanceInitialState: shieldedVMStateConfig,
+		StorageLocations:             storageLocations,
+		Architecture:                 architecture,
+		ImageEncryptionKey:           toComputeEncryptionKey(key),
+	}
+
+	go d.insertImageAndWait(project, image, imageCh, errCh)
+	return imageCh, errCh
+}
+
+func (d *DriverGCE) insertImageAndWait(project string, image *compute.Image, imageCh chan<- *compute.Image, errCh chan<- error) {
+	op, err := d.computeService.Images.Insert(project, image).Do()
+	if err != nil {
+		errCh <- fmt.Errorf("failed to create image %s: %s", image.Name, err)
+		return
+	}
+
+	if err := d.waitForGlobalOperation(project, op); err != nil {
+		errCh <- err
+		return
+	}
+
+	result, err := d.computeService.Images.Get(project, image.Name).Do()
+	if err != nil {
+		errCh <- fmt.Errorf("image %s was created but could not be fetched: %s", image.Name, err)
+		return
+	}
+	imageCh <- result
+}
+
+func (d *DriverGCE) waitForGlobalOperation(project string, op *compute.Operation) error {
+	for {
+		if op.Status == "DONE" {
+			if op.Error != nil && len(op.Error.Errors) > 0 {
+				return fmt.Errorf("operation %s failed: %s", op.Name, op.Error.Errors[0].Message)
+			}
+			return nil
+		}
+
+		time.Sleep(5 * time.Second)
+		var err error
+		op, err = d.computeService.GlobalOperations.Get(project, op.Name).Do()
+		if err != nil {
+			return fmt.Errorf("failed to poll operation %s: %s", op.Name, err)
+		}
+	}
+}
+
+func guestOsFeaturesFromStrings(features []string) []*compute.GuestOsFeature {
+	var result []*compute.GuestOsFeature
+	for _, f := range features {
+		result = append(result, &compute.GuestOsFeature{Type: f})
+	}
+	return result
+}
+
+func toComputeEncryptionKey(key *CustomerEncryptionKey) *compute.CustomerEncryptionKey {
+	if key == nil {
+		return nil
+	}
+	return &compute.CustomerEncryptionKey{
+		KmsKeyName:      key.KmsKeyName,
+		RawKey:          key.RawKey,
+		RsaEncryptedKey: key.RsaEncryptedKey,
+	}
+}
+
+// ImportVirtualDisk imports a non-RAW virtual disk via GCE's virtual disk
+// import workflow. See import_workflow.go for the implementation.
+func (d *DriverGCE) ImportVirtualDisk(ctx context.Context, project, diskGcsPath, sourceFormat, name, description, family string, labels map[string]string, guestOsFeatures []string, shieldedVMStateConfig *compute.InitialStateConfig, storageLocations []string, architecture string, key *CustomerEncryptionKey) (<-chan *compute.Image, <-chan error) {
+	imageCh := make(chan *compute.Image, 1)
+	errCh := make(chan error, 1)
+
+	go d.runImportWorkflow(ctx, project, diskGcsPath, sourceFormat, name, description, family, labels, guestOsFeatures, shieldedVMStateConfig, storageLocations, architecture, key, imageCh, errCh)
+	return imageCh, errCh
+}
+
+// CreateMachineImage creates a GCE Machine Image. See machine_image.go for
+// the implementation.
+func (d *DriverGCE) CreateMachineImage(project, name, description, sourceImageSelfLink string, storageLocations []string, sourceInstanceProperties *SourceInstanceProperties) (*compute.MachineImage, error) {
+	return d.createMachineImage(project, name, description, sourceImageSelfLink, storageLocations, sourceInstanceProperties)
+}
+
+// ExportImageToGCS exports an image's disk to GCS. See export.go for the
+// implementation.
+func (d *DriverGCE) ExportImageToGCS(ctx context.Context, project, imageSelfLink, destination string, exportCfg ExportConfig) error {
+	return d.exportImageToGCS(ctx, project, imageSelfLink, destination, exportCfg)
+}
+
+func isNotFound(err error) bool {
+	return err != nil && (err.Error() == "storage: object doesn't exist" || containsStatus(err, http.StatusNotFound))
+}
+
+func containsStatus(err error, code int) bool {
+	type httpStatuser interface{ Code() int }
+	if hs, ok := err.(httpStatuser); ok {
+		return hs.Code() == code
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/cloudbuild/v1"
+)
+
+// exportDiskToolImage is the public container image that runs
+// Google's export tool, which dd's a boot disk to a GCS destination.
+const exportDiskToolImage = "gcr.io/compute-image-tools/gce_vm_image_export:release"
+
+func (d *DriverGCE) exportImageToGCS(ctx context.Context, project, imageSelfLink, destination string, cfg ExportConfig) error {
+	zone := cfg.Zone
+	if zone == "" {
+		zone = "us-central1-a"
+	}
+	instanceName := fmt.Sprintf("packer-export-%s", selfLinkToName(destination))
+
+	opts := temporaryInstanceOptions{
+		MachineType:         cfg.MachineType,
+		DiskSizeGb:          cfg.DiskSizeGb,
+		DiskType:            cfg.DiskType,
+		Network:             cfg.Network,
+		Subnetwork:          cfg.Subnetwork,
+		ServiceAccountEmail: cfg.ServiceAccountEmail,
+	}
+	instanceSelfLink, err := d.createTemporaryInstance(project, zone, instanceName, imageSelfLink, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary export instance: %s", err)
+	}
+	defer d.deleteInstanceBestEffort(project, zone, instanceName)
+
+	if err := d.runExportBuild(ctx, project, instanceSelfLink, destination); err != nil {
+		return fmt.Errorf("failed to export disk to %s: %s", destination, err)
+	}
+	return nil
+}
+
+// runExportBuild submits a Cloud Build job running Google's disk export
+// tool, which attaches the source instance's boot disk, dd's it, and
+// streams the result to destination, then waits for it to finish.
+func (d *DriverGCE) runExportBuild(ctx context.Context, project, instanceSelfLink, destination string) error {
+	build := &cloudbuild.Build{
+		Steps: []*cloudbuild.BuildStep{
+			{
+				Name: exportDiskToolImage,
+				Args: []string{
+					fmt.Sprintf("-source_instance=%s", instanceSelfLink),
+					fmt.Sprintf("-destination_uri=%s", destination),
+				},
+			},
+		},
+		Timeout: "86400s",
+	}
+
+	op, err := d.cloudbuildSvc.Projects.Builds.Create(project, build).Do()
+	if err != nil {
+		return fmt.Errorf("failed to start export workflow: %s", err)
+	}
+
+	buildID, err := cloudBuildIDFromOperation(op)
+	if err != nil {
+		return err
+	}
+
+	return d.waitForCloudBuild(ctx, project, buildID)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/compute/v1"
+)
+
+// importWorkflowImage is the public container image that runs Google's
+// Daisy-based virtual disk import workflow inside a Cloud Build step.
+const importWorkflowImage = "gcr.io/compute-image-tools/gce_vm_image_import:release"
+
+// qemuImgFormats maps a source_disk_format value to the value of the
+// "-source_disk_format" flag passed to GCE's virtual disk import tool.
+// These are qemu-img's own format names, since the import tool detects
+// and converts the source disk with qemu-img internally; they match the
+// sibling qemuImgSourceFormats map in the googlecompute-import
+// post-processor, which performs the same conversion locally.
+var qemuImgFormats = map[string]string{
+	"vmdk":  "vmdk",
+	"vhd":   "vpc",
+	"vhdx":  "vhdx",
+	"qcow2": "qcow2",
+}
+
+func (d *DriverGCE) runImportWorkflow(ctx context.Context, project, diskGcsPath, sourceFormat, name, description, family string, labels map[string]string, guestOsFeatures []string, shieldedVMStateConfig *compute.InitialStateConfig, storageLocations []string, architecture string, key *CustomerEncryptionKey, imageCh chan<- *compute.Image, errCh chan<- error) {
+	importFormat, ok := qemuImgFormats[sourceFormat]
+	if !ok {
+		errCh <- fmt.Errorf("unsupported source_disk_format for virtual disk import: %s", sourceFormat)
+		return
+	}
+
+	if shieldedVMStateConfig != nil && (shieldedVMStateConfig.Pk != nil || len(shieldedVMStateConfig.Keks) > 0 || len(shieldedVMStateConfig.Dbs) > 0 || len(shieldedVMStateConfig.Dbxs) > 0) {
+		errCh <- fmt.Errorf("custom UEFI secure boot keys (image_platform_key, image_key_exchange_key, image_signatures_db, image_forbidden_signatures_db) are not supported when importing via the GCE virtual disk import workflow; set use_qemu_img_conversion to true instead")
+		return
+	}
+
+	if key != nil && key.KmsKeyName == "" && (key.RawKey != "" || key.RsaEncryptedKey != "") {
+		errCh <- fmt.Errorf("image_encryption_key: only kms_key_name is supported when importing via the GCE virtual disk import workflow; set use_qemu_img_conversion to true to use raw_key or rsa_encrypted_key")
+		return
+	}
+
+	args := []string{
+		fmt.Sprintf("-image_name=%s", name),
+		fmt.Sprintf("-source_file=%s", diskGcsPath),
+		fmt.Sprintf("-source_disk_format=%s", importFormat),
+		fmt.Sprintf("-project=%s", project),
+	}
+	if description != "" {
+		args = append(args, fmt.Sprintf("-description=%s", description))
+	}
+	if family != "" {
+		args = append(args, fmt.Sprintf("-family=%s", family))
+	}
+	if architecture != "" && architecture != "ARCHITECTURE_UNSPECIFIED" {
+		args = append(args, fmt.Sprintf("-architecture=%s", architecture))
+	}
+	if len(guestOsFeatures) > 0 {
+		args = append(args, fmt.Sprintf("-guest-os-features=%s", strings.Join(guestOsFeatures, ",")))
+	}
+	if len(storageLocations) > 0 {
+		args = append(args, fmt.Sprintf("-storage-location=%s", strings.Join(storageLocations, ",")))
+	}
+	if len(labels) > 0 {
+		args = append(args, fmt.Sprintf("-labels=%s", labelsFlag(labels)))
+	}
+	if key != nil && key.KmsKeyName != "" {
+		args = append(args, fmt.Sprintf("-kms_key=%s", key.KmsKeyName))
+	}
+
+	build := &cloudbuild.Build{
+		Steps: []*cloudbuild.BuildStep{
+			{
+				Name: importWorkflowImage,
+				Args: args,
+			},
+		},
+		Timeout: "86400s",
+	}
+
+	op, err := d.cloudbuildSvc.Projects.Builds.Create(project, build).Do()
+	if err != nil {
+		errCh <- fmt.Errorf("failed to start virtual disk import workflow: %s", err)
+		return
+	}
+
+	buildID, err := cloudBuildIDFromOperation(op)
+	if err != nil {
+		errCh <- err
+		return
+	}
+
+	if err := d.waitForCloudBuild(ctx, project, buildID); err != nil {
+		errCh <- err
+		return
+	}
+
+	image, err := d.computeService.Images.Get(project, name).Do()
+	if err != nil {
+		errCh <- fmt.Errorf("virtual disk import workflow finished but image %s could not be fetched: %s", name, err)
+		return
+	}
+	imageCh <- image
+}
+
+// labelsFlag renders labels as the comma-separated key=value list the
+// import tool's -labels flag expects, in a deterministic (sorted) order.
+func labelsFlag(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, labels[k]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (d *DriverGCE) waitForCloudBuild(ctx context.Context, project, buildID string) error {
+	for {
+		build, err := d.cloudbuildSvc.Projects.Builds.Get(project, buildID).Do()
+		if err != nil {
+			return fmt.Errorf("failed to poll virtual disk import workflow: %s", err)
+		}
+
+		switch build.Status {
+		case "SUCCESS":
+			return nil
+		case "FAILURE", "INTERNAL_ERROR", "TIMEOUT", "CANCELLED", "EXPIRED":
+			return fmt.Errorf("virtual disk import workflow %s: %s", build.Status, build.StatusDetail)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// cloudBuildIDFromOperation extracts the build ID the Cloud Build API
+// returns in the metadata of the long-running operation started by
+// Builds.Create.
+func cloudBuildIDFromOperation(op *cloudbuild.Operation) (string, error) {
+	if op.Metadata == nil {
+		return "", fmt.Errorf("cloud build operation response did not include build metadata")
+	}
+	var meta struct {
+		Build struct {
+			ID string `json:"id"`
+		} `json:"build"`
+	}
+	if err := json.Unmarshal(op.Metadata, &meta); err != nil {
+		return "", fmt.Errorf("failed to parse cloud build operation metadata: %s", err)
+	}
+	if meta.Build.ID == "" {
+		return "", fmt.Errorf("cloud build operation metadata did not include a build id")
+	}
+	return meta.Build.ID, nil
+}
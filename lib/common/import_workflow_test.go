@@ -0,0 +1,38 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import "testing"
+
+func TestLabelsFlag(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"nil labels", nil, ""},
+		{"single label", map[string]string{"env": "prod"}, "env=prod"},
+		{"sorted by key", map[string]string{"b": "2", "a": "1"}, "a=1,b=2"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := labelsFlag(tc.labels); got != tc.want {
+				t.Errorf("labelsFlag(%v) = %q, want %q", tc.labels, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQemuImgFormats(t *testing.T) {
+	// vhd and vhdx are distinct qemu-img formats ("vpc" and "vhdx"
+	// respectively); regressing either back to the other silently
+	// imports the wrong disk format.
+	if qemuImgFormats["vhd"] != "vpc" {
+		t.Errorf(`qemuImgFormats["vhd"] = %q, want "vpc"`, qemuImgFormats["vhd"])
+	}
+	if qemuImgFormats["vhdx"] != "vhdx" {
+		t.Errorf(`qemuImgFormats["vhdx"] = %q, want "vhdx"`, qemuImgFormats["vhdx"])
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// temporaryInstanceOptions configures the instance createTemporaryInstance
+// boots. Zero values fall back to GCE/packer-wide defaults (e2-standard-4,
+// the image's own disk size, pd-standard, the default network, the
+// project's default Compute Engine service account).
+type temporaryInstanceOptions struct {
+	MachineType         string
+	DiskSizeGb          int64
+	DiskType            string
+	Network             string
+	Subnetwork          string
+	ServiceAccountEmail string
+	GuestAccelerators   []GuestAccelerator
+}
+
+// createTemporaryInstance boots an instance named instanceName in zone
+// from sourceImageSelfLink and waits for it to be RUNNING, returning its
+// self-link. Callers are responsible for tearing it down, e.g. with
+// deleteInstanceBestEffort.
+func (d *DriverGCE) createTemporaryInstance(project, zone, instanceName, sourceImageSelfLink string, opts temporaryInstanceOptions) (string, error) {
+	machineType := opts.MachineType
+	if machineType == "" {
+		machineType = "e2-standard-4"
+	}
+
+	network := opts.Network
+	if network == "" {
+		network = "global/networks/default"
+	} else {
+		network = fmt.Sprintf("global/networks/%s", network)
+	}
+	networkInterface := &compute.NetworkInterface{Network: network}
+	if opts.Subnetwork != "" {
+		networkInterface.Subnetwork = fmt.Sprintf("regions/%s/subnetworks/%s", regionFromZone(zone), opts.Subnetwork)
+	}
+
+	initializeParams := &compute.AttachedDiskInitializeParams{
+		SourceImage: sourceImageSelfLink,
+		DiskSizeGb:  opts.DiskSizeGb,
+	}
+	if opts.DiskType != "" {
+		initializeParams.DiskType = fmt.Sprintf("zones/%s/diskTypes/%s", zone, opts.DiskType)
+	}
+
+	var serviceAccounts []*compute.ServiceAccount
+	if opts.ServiceAccountEmail != "" {
+		serviceAccounts = []*compute.ServiceAccount{
+			{Email: opts.ServiceAccountEmail, Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}},
+		}
+	}
+
+	var accelerators []*compute.AcceleratorConfig
+	for _, ga := range opts.GuestAccelerators {
+		accelerators = append(accelerators, &compute.AcceleratorConfig{
+			AcceleratorType:  ga.Type,
+			AcceleratorCount: ga.Count,
+		})
+	}
+
+	instance := &compute.Instance{
+		Name:        instanceName,
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", zone, machineType),
+		Disks: []*compute.AttachedDisk{
+			{
+				Boot:             true,
+				AutoDelete:       true,
+				InitializeParams: initializeParams,
+			},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{networkInterface},
+		ServiceAccounts:   serviceAccounts,
+		GuestAccelerators: accelerators,
+	}
+
+	op, err := d.computeService.Instances.Insert(project, zone, instance).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to insert instance %s: %s", instanceName, err)
+	}
+	if err := d.waitForZoneOperation(project, zone, op); err != nil {
+		return "", err
+	}
+
+	created, err := d.computeService.Instances.Get(project, zone, instanceName).Do()
+	if err != nil {
+		return "", fmt.Errorf("instance %s was created but could not be fetched: %s", instanceName, err)
+	}
+	return created.SelfLink, nil
+}
+
+// regionFromZone derives a region name from a zone name, e.g.
+// "us-central1-a" -> "us-central1".
+func regionFromZone(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i != -1 {
+		return zone[:i]
+	}
+	return zone
+}
+
+func (d *DriverGCE) deleteInstanceBestEffort(project, zone, instanceName string) {
+	op, err := d.computeService.Instances.Delete(project, zone, instanceName).Do()
+	if err != nil {
+		if d.ui != nil {
+			d.ui.Say(fmt.Sprintf("warning: failed to delete temporary instance %s: %s", instanceName, err))
+		}
+		return
+	}
+	if err := d.waitForZoneOperation(project, zone, op); err != nil && d.ui != nil {
+		d.ui.Say(fmt.Sprintf("warning: failed to confirm deletion of temporary instance %s: %s", instanceName, err))
+	}
+}
+
+func (d *DriverGCE) waitForZoneOperation(project, zone string, op *compute.Operation) error {
+	for {
+		if op.Status == "DONE" {
+			if op.Error != nil && len(op.Error.Errors) > 0 {
+				return fmt.Errorf("operation %s failed: %s", op.Name, op.Error.Errors[0].Message)
+			}
+			return nil
+		}
+
+		time.Sleep(5 * time.Second)
+		var err error
+		op, err = d.computeService.ZoneOperations.Get(project, zone, op.Name).Do()
+		if err != nil {
+			return fmt.Errorf("failed to poll operation %s: %s", op.Name, err)
+		}
+	}
+}
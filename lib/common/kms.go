@@ -0,0 +1,90 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
+)
+
+// requiredKMSPermissions are the IAM permissions, both granted by
+// roles/cloudkms.cryptoKeyEncrypterDecrypter, needed to encrypt and
+// decrypt an object/image with a customer-managed Cloud KMS key.
+var requiredKMSPermissions = []string{
+	"cloudkms.cryptoKeyVersions.useToEncrypt",
+	"cloudkms.cryptoKeyVersions.useToDecrypt",
+}
+
+// ValidateKMSPermissions checks that key is well-formed and, if it names a
+// Cloud KMS key, that the given credentials hold
+// roles/cloudkms.cryptoKeyEncrypterDecrypter (or an equivalent custom role)
+// on it. It is called from Configure so that a missing grant is reported
+// before a multi-GB upload starts, rather than after it completes.
+func ValidateKMSPermissions(key *CustomerEncryptionKey, credentials *google.Credentials) error {
+	if key == nil {
+		return nil
+	}
+
+	set := 0
+	for _, v := range []string{key.KmsKeyName, key.RawKey, key.RsaEncryptedKey} {
+		if v != "" {
+			set++
+		}
+	}
+	switch set {
+	case 0:
+		return fmt.Errorf("exactly one of kms_key_name, raw_key, or rsa_encrypted_key must be set")
+	case 1:
+		// ok
+	default:
+		return fmt.Errorf("only one of kms_key_name, raw_key, or rsa_encrypted_key may be set")
+	}
+
+	if key.KmsKeyName == "" {
+		// Raw/RSA-wrapped keys aren't IAM-protected resources; there's
+		// nothing further to validate.
+		return nil
+	}
+
+	if credentials == nil {
+		return fmt.Errorf("credentials must be configured to validate permissions on kms_key_name %s", key.KmsKeyName)
+	}
+
+	kmsService, err := cloudkms.NewService(context.Background(), option.WithTokenSource(credentials.TokenSource))
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud KMS client to validate kms_key_name: %s", err)
+	}
+
+	resp, err := kmsService.Projects.Locations.KeyRings.CryptoKeys.TestIamPermissions(
+		key.KmsKeyName,
+		&cloudkms.TestIamPermissionsRequest{Permissions: requiredKMSPermissions},
+	).Do()
+	if err != nil {
+		return fmt.Errorf("failed to check IAM permissions on kms_key_name %s: %s", key.KmsKeyName, err)
+	}
+
+	if len(resp.Permissions) < len(requiredKMSPermissions) {
+		return fmt.Errorf(
+			"the configured credentials are missing some of %v on kms_key_name %s; grant roles/cloudkms.cryptoKeyEncrypterDecrypter",
+			requiredKMSPermissions, key.KmsKeyName)
+	}
+
+	return nil
+}
+
+// ValidateGCSObjectEncryptionKey validates key for use as
+// gcs_object_encryption_key. Unlike a GCE image's encryption key, GCS's
+// customer-supplied encryption key (CSEK) headers only support a raw
+// AES-256 key, not an RSA-wrapped one, so rsa_encrypted_key is rejected
+// here even though ValidateKMSPermissions otherwise accepts it.
+func ValidateGCSObjectEncryptionKey(key *CustomerEncryptionKey, credentials *google.Credentials) error {
+	if key != nil && key.RsaEncryptedKey != "" {
+		return fmt.Errorf("rsa_encrypted_key is not supported for gcs_object_encryption_key; GCS object encryption only supports raw_key or kms_key_name")
+	}
+	return ValidateKMSPermissions(key, credentials)
+}
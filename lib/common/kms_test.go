@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import "testing"
+
+func TestValidateKMSPermissions_KeyShape(t *testing.T) {
+	cases := []struct {
+		name    string
+		key     *CustomerEncryptionKey
+		wantErr bool
+	}{
+		{"nil key is fine", nil, false},
+		{"no fields set", &CustomerEncryptionKey{}, true},
+		{"raw key only", &CustomerEncryptionKey{RawKey: "c2VjcmV0"}, false},
+		{"rsa key only", &CustomerEncryptionKey{RsaEncryptedKey: "c2VjcmV0"}, false},
+		{"raw and rsa both set", &CustomerEncryptionKey{RawKey: "a", RsaEncryptedKey: "b"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateKMSPermissions(tc.key, nil)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("ValidateKMSPermissions(%+v) error = %v, wantErr %v", tc.key, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateGCSObjectEncryptionKey_RejectsRsaEncryptedKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		key     *CustomerEncryptionKey
+		wantErr bool
+	}{
+		{"nil key is fine", nil, false},
+		{"raw key only", &CustomerEncryptionKey{RawKey: "c2VjcmV0"}, false},
+		{"rsa key only is rejected", &CustomerEncryptionKey{RsaEncryptedKey: "c2VjcmV0"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateGCSObjectEncryptionKey(tc.key, nil)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("ValidateGCSObjectEncryptionKey(%+v) error = %v, wantErr %v", tc.key, err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"fmt"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// createMachineImage creates a GCE Machine Image from an already-created
+// disk image. Machine Images are natively created from a *running
+// instance*; since the googlecompute-import post-processor only has a
+// disk image at this point, it first boots a short-lived instance from
+// that image and points MachineImages.Insert at the instance instead.
+func (d *DriverGCE) createMachineImage(project, name, description, sourceImageSelfLink string, storageLocations []string, sourceInstanceProperties *SourceInstanceProperties) (*compute.MachineImage, error) {
+	zone := "us-central1-a"
+
+	var opts temporaryInstanceOptions
+	if sourceInstanceProperties != nil {
+		opts.MachineType = sourceInstanceProperties.MachineType
+		opts.GuestAccelerators = sourceInstanceProperties.GuestAccelerators
+	}
+
+	instanceSelfLink, err := d.createTemporaryInstance(project, zone, name+"-machine-image-src", sourceImageSelfLink, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary source instance for machine image: %s", err)
+	}
+	defer d.deleteInstanceBestEffort(project, zone, name+"-machine-image-src")
+
+	machineImage := &compute.MachineImage{
+		Name:                     name,
+		Description:              description,
+		SourceInstance:           instanceSelfLink,
+		SourceInstanceProperties: toComputeSourceInstanceProperties(sourceInstanceProperties),
+		StorageLocations:         storageLocations,
+	}
+
+	op, err := d.computeService.MachineImages.Insert(project, machineImage).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create machine image %s: %s", name, err)
+	}
+
+	if err := d.waitForGlobalOperation(project, op); err != nil {
+		return nil, err
+	}
+
+	return d.computeService.MachineImages.Get(project, name).Do()
+}
+
+func toComputeSourceInstanceProperties(p *SourceInstanceProperties) *compute.SourceInstanceProperties {
+	if p == nil {
+		return nil
+	}
+	sip := &compute.SourceInstanceProperties{
+		MachineType: p.MachineType,
+	}
+	for _, ga := range p.GuestAccelerators {
+		sip.GuestAccelerators = append(sip.GuestAccelerators, &compute.AcceleratorConfig{
+			AcceleratorType:  ga.Type,
+			AcceleratorCount: ga.Count,
+		})
+	}
+	return sip
+}
+
+// selfLinkToName returns the last path segment of a GCE resource self-link,
+// which is the resource's short name.
+func selfLinkToName(selfLink string) string {
+	for i := len(selfLink) - 1; i >= 0; i-- {
+		if selfLink[i] == '/' {
+			return selfLink[i+1:]
+		}
+	}
+	return selfLink
+}
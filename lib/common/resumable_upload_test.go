@@ -0,0 +1,84 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestValidateUploadChunkSizeMB(t *testing.T) {
+	cases := []struct {
+		mb      int
+		wantErr bool
+	}{
+		{0, false},
+		{16, false},
+		{1, false},
+		{-1, true},
+	}
+
+	for _, tc := range cases {
+		err := ValidateUploadChunkSizeMB(tc.mb)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ValidateUploadChunkSizeMB(%d) error = %v, wantErr %v", tc.mb, err, tc.wantErr)
+		}
+	}
+}
+
+func TestApplyEncryptionHeaders(t *testing.T) {
+	newReq := func() *http.Request {
+		req, err := http.NewRequest(http.MethodPost, "https://storage.googleapis.com/upload", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest: %s", err)
+		}
+		return req
+	}
+
+	t.Run("nil key sets nothing", func(t *testing.T) {
+		req := newReq()
+		if err := applyEncryptionHeaders(req, nil); err != nil {
+			t.Fatalf("applyEncryptionHeaders: %s", err)
+		}
+		if req.Header.Get("x-goog-encryption-algorithm") != "" {
+			t.Errorf("expected no encryption header, got %q", req.Header.Get("x-goog-encryption-algorithm"))
+		}
+	})
+
+	t.Run("kms_key_name sets the query param", func(t *testing.T) {
+		req := newReq()
+		key := &CustomerEncryptionKey{KmsKeyName: "projects/p/locations/l/keyRings/r/cryptoKeys/k"}
+		if err := applyEncryptionHeaders(req, key); err != nil {
+			t.Fatalf("applyEncryptionHeaders: %s", err)
+		}
+		if got := req.URL.Query().Get("kmsKeyName"); got != key.KmsKeyName {
+			t.Errorf("kmsKeyName query param = %q, want %q", got, key.KmsKeyName)
+		}
+	})
+
+	t.Run("raw_key sets algorithm, key, and sha256 headers", func(t *testing.T) {
+		req := newReq()
+		key := &CustomerEncryptionKey{RawKey: "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0IQ=="}
+		if err := applyEncryptionHeaders(req, key); err != nil {
+			t.Fatalf("applyEncryptionHeaders: %s", err)
+		}
+		if got := req.Header.Get("x-goog-encryption-algorithm"); got != "AES256" {
+			t.Errorf("x-goog-encryption-algorithm = %q, want AES256", got)
+		}
+		if got := req.Header.Get("x-goog-encryption-key"); got != key.RawKey {
+			t.Errorf("x-goog-encryption-key = %q, want %q", got, key.RawKey)
+		}
+		if req.Header.Get("x-goog-encryption-key-sha256") == "" {
+			t.Error("x-goog-encryption-key-sha256 header was not set")
+		}
+	})
+
+	t.Run("raw_key with invalid base64 is an error", func(t *testing.T) {
+		req := newReq()
+		key := &CustomerEncryptionKey{RawKey: "not-valid-base64!!"}
+		if err := applyEncryptionHeaders(req, key); err == nil {
+			t.Error("expected an error for invalid base64 raw_key, got nil")
+		}
+	})
+}
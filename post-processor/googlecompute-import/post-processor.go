@@ -7,16 +7,22 @@
 package googlecomputeimport
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"context"
+	"crypto/md5"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"strings"
+	"time"
 
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/storage/v1"
@@ -98,6 +104,34 @@ type Config struct {
 	//may use user variables and template functions in this field. Defaults to
 	//`packer-import-{{timestamp}}.tar.gz`.
 	GCSObjectName string `mapstructure:"gcs_object_name"`
+	// The format of the source disk artifact produced by the upstream build
+	// step. Must be one of `raw`, `vmdk`, `vhd`, `vhdx`, or `qcow2`. Defaults
+	// to `raw`, which is the only format the `compress` and `artifice`
+	// post-processors can produce directly. Any other value allows importing
+	// the `qemu`, `vmware-iso`, and `virtualbox-iso` builders' native disk
+	// formats without a RAW conversion step of your own: the uploaded
+	// artifact is converted to a bootable GCE image by GCE's virtual disk
+	// import workflow (Cloud Build + Daisy), unless `use_qemu_img_conversion`
+	// is set. The artifact must still come from the `compress` or `artifice`
+	// post-processor; artifacts of any other type are rejected.
+	SourceDiskFormat string `mapstructure:"source_disk_format" required:"false"`
+	// When `source_disk_format` is not `raw`, convert the source disk to RAW
+	// locally with `qemu-img convert` before uploading, rather than letting
+	// GCE's virtual disk import workflow perform the conversion server-side.
+	// Requires `qemu-img` to be installed and on the `PATH`. Defaults to
+	// `false`.
+	UseQemuImgConversion bool `mapstructure:"use_qemu_img_conversion" required:"false"`
+	// The amount of time to wait for the GCE virtual disk import workflow to
+	// complete before giving up. Only applies when `source_disk_format` is
+	// not `raw` and `use_qemu_img_conversion` is `false`. Defaults to `20m`.
+	ImportWorkflowTimeout config.Duration `mapstructure:"import_workflow_timeout" required:"false"`
+	// The chunk size, in MiB, used for the resumable upload of the source
+	// disk artifact to `bucket`. Must be a multiple of 0.25 MiB (256 KiB),
+	// which is the chunk granularity required by the GCS JSON API's
+	// resumable upload protocol. Larger chunks mean fewer HTTP round trips
+	// at the cost of re-sending more data if a chunk upload fails. Defaults
+	// to `16`.
+	UploadChunkSizeMB int `mapstructure:"upload_chunk_size_mb" required:"false"`
 	// Specifies the architecture or processor type that this image can support. Must be one of: `arm64` or `x86_64`. Defaults to `ARCHITECTURE_UNSPECIFIED`.
 	ImageArchitecture string `mapstructure:"image_architecture"`
 	//The description of the resulting image.
@@ -127,6 +161,80 @@ type Config struct {
 	ImageSignaturesDB []string `mapstructure:"image_signatures_db"`
 	//A database of certificates that have been revoked and will cause the system to stop booting if a boot file is signed with one of them. You may specify single or multiple comma-separated values for this value.
 	ImageForbiddenSignaturesDB []string `mapstructure:"image_forbidden_signatures_db"`
+	// The customer-managed encryption key used to encrypt the resulting
+	// image, or `nil` if the image is not encrypted with a
+	// customer-managed key. Either a Cloud KMS `kms_key_name` or a raw
+	// base64 `raw_key`/`rsa_encrypted_key`, mirroring `customer_encryption_key`
+	// on the `googlecompute` builder. When the image is created by GCE's
+	// virtual disk import workflow, only `kms_key_name` is supported.
+	ImageEncryptionKey *common.CustomerEncryptionKey `mapstructure:"image_encryption_key" required:"false"`
+	// The customer-managed encryption key used to encrypt the uploaded GCS
+	// object, or `nil` if the object should use Google-managed encryption.
+	// Accepts `kms_key_name` or `raw_key`; `rsa_encrypted_key` is rejected,
+	// since GCS object encryption does not support RSA-wrapped keys.
+	GCSObjectEncryptionKey *common.CustomerEncryptionKey `mapstructure:"gcs_object_encryption_key" required:"false"`
+
+	// A list of GCS destination URIs (e.g. `gs://bucket/path/disk.tar.gz`)
+	// to export the resulting image's disk to, in addition to creating the
+	// GCE image itself. When set, for each destination a temporary instance
+	// is booted from the new image, `dd`s its boot disk to that destination,
+	// and is torn down automatically. This folds the `googlecompute-export` post-processor's
+	// behavior into a single step, so a second post-processor chained onto
+	// this one is no longer necessary.
+	ExportPaths []string `mapstructure:"export_paths" required:"false"`
+	// The size, in GB, of the temporary disk attached to the export
+	// instance. Defaults to `200`.
+	ExportDiskSizeGb int64 `mapstructure:"export_disk_size_gb" required:"false"`
+	// The disk type (e.g. `pd-ssd`, `pd-standard`) for the temporary export
+	// instance's disk. Defaults to `pd-ssd`.
+	ExportDiskType string `mapstructure:"export_disk_type" required:"false"`
+	// The machine type to use for the temporary export instance. Defaults
+	// to `e2-standard-4`.
+	ExportMachineType string `mapstructure:"export_machine_type" required:"false"`
+	// The zone in which to launch the temporary export instance. Defaults
+	// to `us-central1-a`; the zone is not derived from
+	// `image_storage_locations`.
+	ExportZone string `mapstructure:"export_zone" required:"false"`
+	// The network to attach the temporary export instance to. Defaults to
+	// `default`.
+	ExportNetwork string `mapstructure:"export_network" required:"false"`
+	// The subnetwork to attach the temporary export instance to.
+	ExportSubnetwork string `mapstructure:"export_subnetwork" required:"false"`
+	// The service account email the temporary export instance runs as.
+	// Defaults to the project's default Compute Engine service account.
+	ExportServiceAccountEmail string `mapstructure:"export_service_account_email" required:"false"`
+
+	// The unique name of a GCE Machine Image to create from the resulting
+	// disk image, in addition to the image itself. Machine images bundle a
+	// disk image with instance configuration and IAM policy, and are the
+	// recommended distribution unit for GCE fleets. Leave unset to skip
+	// machine image creation.
+	MachineImageName string `mapstructure:"machine_image_name" required:"false"`
+	// The description of the resulting machine image.
+	MachineImageDescription string `mapstructure:"machine_image_description" required:"false"`
+	// A list of Cloud Storage locations, either regional or multi-regional,
+	// where the machine image content is to be stored. If not specified,
+	// the multi-region location closest to the source is chosen
+	// automatically.
+	MachineImageStorageLocations []string `mapstructure:"machine_image_storage_locations" required:"false"`
+	// Instance properties to associate with the source instance the
+	// machine image is derived from, such as guest accelerators and a
+	// machine type hint. Only used when `machine_image_name` is set.
+	MachineImageSourceInstanceProperties *common.SourceInstanceProperties `mapstructure:"machine_image_source_instance_properties" required:"false"`
+
+	// When true, compute the hash of the local tarball before uploading
+	// and skip the upload if an object already exists at `gcs_object_name`
+	// in `bucket` with a matching hash, proceeding directly to image
+	// creation using the existing object. Defaults to `false`.
+	SkipUploadIfExists bool `mapstructure:"skip_upload_if_exists" required:"false"`
+	// The hash algorithm to use for the `skip_upload_if_exists` comparison.
+	// Must be one of `md5` or `crc32c`. Defaults to `md5`.
+	ContentHashAlgorithm string `mapstructure:"content_hash_algorithm" required:"false"`
+	// When a differing object already exists at `gcs_object_name`, refuse
+	// to overwrite it unless this is set to `true`. This protects CI
+	// pipelines that share a bucket from silently clobbering each other's
+	// in-flight imports. Defaults to `false`.
+	AllowOverwrite bool `mapstructure:"allow_overwrite" required:"false"`
 
 	ctx interpolate.Context
 }
@@ -135,6 +243,17 @@ type PostProcessor struct {
 	config Config
 }
 
+// diskFormatExtensions maps a supported source_disk_format to the file
+// extension findTarballFromArtifact should look for among the upstream
+// artifact's files.
+var diskFormatExtensions = map[string]string{
+	"raw":   ".tar.gz",
+	"vmdk":  ".vmdk",
+	"vhd":   ".vhd",
+	"vhdx":  ".vhdx",
+	"qcow2": ".qcow2",
+}
+
 func (p *PostProcessor) CheckAuth() error {
 	return googlecompute.CheckAuth(
 		p.config.AccessToken,
@@ -176,6 +295,60 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 			errs, fmt.Errorf("Error parsing gcs_object_name template: %s", err))
 	}
 
+	if p.config.SourceDiskFormat == "" {
+		p.config.SourceDiskFormat = "raw"
+	} else {
+		p.config.SourceDiskFormat = strings.ToLower(p.config.SourceDiskFormat)
+		if _, ok := diskFormatExtensions[p.config.SourceDiskFormat]; !ok {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("Invalid source_disk_format: Must be one of raw, vmdk, vhd, vhdx, or qcow2"))
+		}
+	}
+
+	if p.config.ImportWorkflowTimeout.Duration == 0 {
+		p.config.ImportWorkflowTimeout = config.Duration{Duration: 20 * time.Minute}
+	}
+
+	usesImportWorkflow := p.config.SourceDiskFormat != "" && p.config.SourceDiskFormat != "raw" && !p.config.UseQemuImgConversion
+	hasCustomSecureBootKeys := p.config.ImagePlatformKey != "" || len(p.config.ImageKeyExchangeKey) > 0 ||
+		len(p.config.ImageSignaturesDB) > 0 || len(p.config.ImageForbiddenSignaturesDB) > 0
+	if usesImportWorkflow && hasCustomSecureBootKeys {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(
+			"image_platform_key, image_key_exchange_key, image_signatures_db, and image_forbidden_signatures_db are not supported with source_disk_format %s unless use_qemu_img_conversion is true; the GCE virtual disk import workflow cannot apply custom UEFI secure boot keys",
+			p.config.SourceDiskFormat))
+	}
+
+	if p.config.ContentHashAlgorithm == "" {
+		p.config.ContentHashAlgorithm = "md5"
+	} else if p.config.ContentHashAlgorithm != "md5" && p.config.ContentHashAlgorithm != "crc32c" {
+		errs = packersdk.MultiErrorAppend(errs,
+			fmt.Errorf("Invalid content_hash_algorithm: Must be one of md5 or crc32c"))
+	}
+
+	if len(p.config.ExportPaths) > 0 {
+		if p.config.ExportDiskSizeGb == 0 {
+			p.config.ExportDiskSizeGb = 200
+		}
+		if p.config.ExportDiskType == "" {
+			p.config.ExportDiskType = "pd-ssd"
+		}
+		if p.config.ExportMachineType == "" {
+			p.config.ExportMachineType = "e2-standard-4"
+		}
+		if p.config.ExportZone == "" {
+			p.config.ExportZone = "us-central1-a"
+		}
+		if p.config.ExportNetwork == "" {
+			p.config.ExportNetwork = "default"
+		}
+	}
+
+	if err := common.ValidateUploadChunkSizeMB(p.config.UploadChunkSizeMB); err != nil {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	} else if p.config.UploadChunkSizeMB == 0 {
+		p.config.UploadChunkSizeMB = 16
+	}
+
 	if p.config.ImageArchitecture == "" {
 		// Lower case is not required here
 		p.config.ImageArchitecture = "ARCHITECTURE_UNSPECIFIED"
@@ -240,6 +413,18 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		}
 	}
 
+	if p.config.ImageEncryptionKey != nil {
+		if err := common.ValidateKMSPermissions(p.config.ImageEncryptionKey, p.config.credentials); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("image_encryption_key: %s", err))
+		}
+	}
+
+	if p.config.GCSObjectEncryptionKey != nil {
+		if err := common.ValidateGCSObjectEncryptionKey(p.config.GCSObjectEncryptionKey, p.config.credentials); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("gcs_object_encryption_key: %s", err))
+		}
+	}
+
 	if len(errs.Errors) > 0 {
 		return errs
 	}
@@ -257,8 +442,9 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 	var err error
 
 	cfg := &common.GCEDriverConfig{
-		Ui:     ui,
-		Scopes: p.config.Scopes,
+		Ui:                ui,
+		Scopes:            p.config.Scopes,
+		UploadChunkSizeMB: p.config.UploadChunkSizeMB,
 	}
 	p.config.Authentication.ApplyDriverConfig(cfg)
 	driver, err := common.NewDriverGCE(*cfg)
@@ -284,8 +470,59 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 	}
 
 	tarball, err := p.findTarballFromArtifact(artifact)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	if p.config.SourceDiskFormat != "" && p.config.SourceDiskFormat != "raw" && p.config.UseQemuImgConversion {
+		ui.Say(fmt.Sprintf("converting %s source disk to raw with qemu-img", p.config.SourceDiskFormat))
+		file, ok := tarball.(*os.File)
+		if !ok {
+			return nil, false, false, fmt.Errorf("cannot convert in-memory artifact; qemu-img conversion requires a file on disk")
+		}
+		tarball, err = convertToRawTarball(file.Name(), p.config.SourceDiskFormat)
+		if err != nil {
+			return nil, false, false, err
+		}
+	}
+
+	var rawImageGcsPath string
+	freshlyUploaded := true
+
+	if p.config.SkipUploadIfExists {
+		file, ok := tarball.(*os.File)
+		if !ok {
+			return nil, false, false, fmt.Errorf("cannot hash in-memory artifact; skip_upload_if_exists requires a file on disk")
+		}
+
+		localHash, err := hashFile(file.Name(), p.config.ContentHashAlgorithm)
+		if err != nil {
+			return nil, false, false, err
+		}
+
+		remoteHash, exists, err := driver.GetObjectHash(p.config.Bucket, p.config.GCSObjectName, p.config.ContentHashAlgorithm)
+		if err != nil {
+			return nil, false, false, err
+		}
 
-	rawImageGcsPath, err := driver.UploadToBucket(p.config.Bucket, p.config.GCSObjectName, tarball)
+		switch {
+		case exists && remoteHash == localHash:
+			ui.Say(fmt.Sprintf("%s already exists in %s with a matching %s hash, skipping upload", p.config.GCSObjectName, p.config.Bucket, p.config.ContentHashAlgorithm))
+			rawImageGcsPath = fmt.Sprintf("https://storage.googleapis.com/%s/%s", p.config.Bucket, p.config.GCSObjectName)
+			freshlyUploaded = false
+		case exists && !p.config.AllowOverwrite:
+			return nil, false, false, fmt.Errorf(
+				"%s already exists in %s with a different %s hash; set allow_overwrite to overwrite it",
+				p.config.GCSObjectName, p.config.Bucket, p.config.ContentHashAlgorithm)
+		default:
+			rawImageGcsPath, err = driver.UploadToBucket(p.config.Bucket, p.config.GCSObjectName, tarball, p.config.GCSObjectEncryptionKey)
+			if err != nil {
+				return nil, false, false, err
+			}
+		}
+	} else {
+		rawImageGcsPath, err = driver.UploadToBucket(p.config.Bucket, p.config.GCSObjectName, tarball, p.config.GCSObjectEncryptionKey)
+	}
 	if err != nil {
 		return nil, false, false, err
 	}
@@ -298,27 +535,78 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 	var retArtifact *Artifact
 	var retErr error
 
-	imageCh, errCh := driver.CreateImageFromRaw(p.config.ProjectId, rawImageGcsPath, p.config.ImageName, p.config.ImageDescription, p.config.ImageFamily, p.config.ImageLabels, p.config.ImageGuestOsFeatures, shieldedVMStateConfig, p.config.ImageStorageLocations, p.config.ImageArchitecture)
-	select {
-	case img := <-imageCh:
-		retArtifact = &Artifact{
-			paths: []string{
-				img.SelfLink,
-			},
+	if p.config.SourceDiskFormat != "" && p.config.SourceDiskFormat != "raw" && !p.config.UseQemuImgConversion {
+		// Non-RAW formats that aren't converted locally are imported through
+		// GCE's virtual disk import workflow (Cloud Build + Daisy), which is
+		// asynchronous and may take considerably longer than a plain raw
+		// image creation.
+		ui.Say(fmt.Sprintf("importing %s disk via the GCE virtual disk import workflow (timeout %s)", p.config.SourceDiskFormat, p.config.ImportWorkflowTimeout.Duration))
+		importCtx, cancel := context.WithTimeout(ctx, p.config.ImportWorkflowTimeout.Duration)
+		defer cancel()
+
+		imageCh, errCh := driver.ImportVirtualDisk(importCtx, p.config.ProjectId, rawImageGcsPath, p.config.SourceDiskFormat, p.config.ImageName, p.config.ImageDescription, p.config.ImageFamily, p.config.ImageLabels, p.config.ImageGuestOsFeatures, shieldedVMStateConfig, p.config.ImageStorageLocations, p.config.ImageArchitecture, p.config.ImageEncryptionKey)
+		select {
+		case img := <-imageCh:
+			retArtifact = &Artifact{
+				paths: []string{
+					img.SelfLink,
+				},
+			}
+		case err := <-errCh:
+			retErr = err
+		case <-importCtx.Done():
+			retErr = fmt.Errorf("timed out waiting for virtual disk import workflow: %w", importCtx.Err())
+		}
+	} else {
+		imageCh, errCh := driver.CreateImageFromRaw(p.config.ProjectId, rawImageGcsPath, p.config.ImageName, p.config.ImageDescription, p.config.ImageFamily, p.config.ImageLabels, p.config.ImageGuestOsFeatures, shieldedVMStateConfig, p.config.ImageStorageLocations, p.config.ImageArchitecture, p.config.ImageEncryptionKey)
+		select {
+		case img := <-imageCh:
+			retArtifact = &Artifact{
+				paths: []string{
+					img.SelfLink,
+				},
+			}
+		case err := <-errCh:
+			retErr = err
 		}
-	case err := <-errCh:
-		retErr = err
 	}
 
 	if err != nil {
 		ui.Say(fmt.Sprintf("failed to create image from raw disk: %s", err))
 	}
 
-	if !p.config.SkipClean {
+	if retErr == nil && retArtifact != nil {
+		imageSelfLink := retArtifact.paths[0]
+
+		if p.config.MachineImageName != "" {
+			ui.Say(fmt.Sprintf("creating machine image %s from image %s", p.config.MachineImageName, imageSelfLink))
+			machineImage, machineImageErr := driver.CreateMachineImage(p.config.ProjectId, p.config.MachineImageName, p.config.MachineImageDescription, imageSelfLink, p.config.MachineImageStorageLocations, p.config.MachineImageSourceInstanceProperties)
+			if machineImageErr != nil {
+				// The disk image itself was already created successfully;
+				// surface the artifact alongside the error so a flaky
+				// machine-image step doesn't force redoing the whole import.
+				return retArtifact, false, false, machineImageErr
+			}
+			retArtifact.paths = append(retArtifact.paths, machineImage.SelfLink)
+		}
+
+		if len(p.config.ExportPaths) > 0 {
+			exportedPaths, exportErr := p.exportImage(ctx, ui, driver, imageSelfLink)
+			if exportErr != nil {
+				// The disk image itself was already created successfully;
+				// surface the artifact alongside the error so a flaky
+				// export step doesn't force redoing the whole import.
+				return retArtifact, false, false, exportErr
+			}
+			retArtifact.paths = append(retArtifact.paths, exportedPaths...)
+		}
+	}
+
+	if !p.config.SkipClean && freshlyUploaded {
 		ui.Say(fmt.Sprintf("deleting %s from bucket %s", p.config.GCSObjectName, p.config.Bucket))
 		err = driver.DeleteFromBucket(p.config.Bucket, p.config.GCSObjectName)
 		if err != nil {
-			return nil, false, false, err
+			return retArtifact, false, false, err
 		}
 	}
 
@@ -326,21 +614,155 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 }
 
 func (p PostProcessor) findTarballFromArtifact(artifact packersdk.Artifact) (io.Reader, error) {
+	ext := diskFormatExtensions[p.config.SourceDiskFormat]
 	source := ""
 	for _, path := range artifact.Files() {
-		if strings.HasSuffix(path, ".tar.gz") {
+		if strings.HasSuffix(path, ext) {
 			source = path
 			break
 		}
 	}
 
 	if source == "" {
-		return nil, fmt.Errorf("No tar.gz file found in list of artifacts")
+		return nil, fmt.Errorf("No %s file found in list of artifacts", ext)
 	}
 
 	return os.Open(source)
 }
 
+// qemuImgSourceFormats maps a source_disk_format value to the format name
+// qemu-img's `-f` flag expects. Most of our format names match qemu-img's
+// directly; VHD is the exception, since qemu-img calls that format "vpc".
+var qemuImgSourceFormats = map[string]string{
+	"vmdk":  "vmdk",
+	"vhd":   "vpc",
+	"vhdx":  "vhdx",
+	"qcow2": "qcow2",
+}
+
+// convertToRawTarball shells out to `qemu-img convert` to turn a non-RAW
+// source disk into a RAW disk, then packs it into a gzip tarball
+// containing a single `disk.raw` member, matching the layout GCE's
+// raw-disk import expects and CreateImageFromRaw assumes.
+func convertToRawTarball(sourcePath, sourceFormat string) (io.Reader, error) {
+	qemuFormat, ok := qemuImgSourceFormats[sourceFormat]
+	if !ok {
+		return nil, fmt.Errorf("unsupported source_disk_format for qemu-img conversion: %s", sourceFormat)
+	}
+
+	rawPath := sourcePath + ".raw"
+	cmd := exec.Command("qemu-img", "convert", "-f", qemuFormat, "-O", "raw", sourcePath, rawPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("qemu-img convert failed: %s: %s", err, string(out))
+	}
+	defer os.Remove(rawPath)
+
+	tarballPath := sourcePath + ".tar.gz"
+	tarballFile, err := os.Create(tarballPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raw disk tarball: %s", err)
+	}
+	defer tarballFile.Close()
+
+	if err := tarGzipFile(rawPath, "disk.raw", tarballFile); err != nil {
+		return nil, fmt.Errorf("failed to tar raw disk: %s", err)
+	}
+
+	return os.Open(tarballPath)
+}
+
+// tarGzipFile writes a gzip-compressed tar archive containing a single
+// member, memberName, with the contents of sourcePath, to w.
+func tarGzipFile(sourcePath, memberName string, w io.Writer) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name: memberName,
+		Mode: 0644,
+		Size: info.Size(),
+	}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, src); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+// exportImage exports the newly created image to each destination in
+// p.config.ExportPaths in turn; for each one, ExportImageToGCS boots a
+// temporary instance from the image, dd's its boot disk to the
+// destination, and tears the instance down again. It returns the GCS URIs
+// of the exported disks.
+func (p *PostProcessor) exportImage(ctx context.Context, ui packersdk.Ui, driver common.Driver, imageSelfLink string) ([]string, error) {
+	exportConfig := common.ExportConfig{
+		DiskSizeGb:          p.config.ExportDiskSizeGb,
+		DiskType:            p.config.ExportDiskType,
+		MachineType:         p.config.ExportMachineType,
+		Zone:                p.config.ExportZone,
+		Network:             p.config.ExportNetwork,
+		Subnetwork:          p.config.ExportSubnetwork,
+		ServiceAccountEmail: p.config.ExportServiceAccountEmail,
+	}
+
+	exportedPaths := make([]string, 0, len(p.config.ExportPaths))
+	for _, destination := range p.config.ExportPaths {
+		ui.Say(fmt.Sprintf("exporting image %s to %s", imageSelfLink, destination))
+		if err := driver.ExportImageToGCS(ctx, p.config.ProjectId, imageSelfLink, destination, exportConfig); err != nil {
+			return exportedPaths, fmt.Errorf("failed to export image to %s: %s", destination, err)
+		}
+		exportedPaths = append(exportedPaths, destination)
+	}
+
+	return exportedPaths, nil
+}
+
+// hashFile returns the base64-encoded hash of the file at path, using
+// algorithm "md5" or "crc32c" to match the format GCS returns for an
+// object's md5Hash/crc32c metadata.
+func hashFile(path, algorithm string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	switch algorithm {
+	case "crc32c":
+		h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		sum := make([]byte, 4)
+		sum[0] = byte(h.Sum32() >> 24)
+		sum[1] = byte(h.Sum32() >> 16)
+		sum[2] = byte(h.Sum32() >> 8)
+		sum[3] = byte(h.Sum32())
+		return base64.StdEncoding.EncodeToString(sum), nil
+	default:
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
 func FillFileContentBuffer(certOrKeyFile string) (*compute.FileContentBuffer, error) {
 	data, err := ioutil.ReadFile(certOrKeyFile)
 	if err != nil {